@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+  healthState is Mambo's self-observability: one place every controller/mysqlWorker reports
+  into, and the admin server's /readyz and /debug/vars read back out of. It lets an
+  operator tell a stalled metric apart from a wedged process, a slow query, or a downed MySQL
+  target, which a bare ticker loop with no feedback never could.
+*/
+type healthState struct {
+	mu              sync.Mutex
+	ready           bool
+	lastSuccess     map[string]time.Time
+	lastDuration    map[string]time.Duration
+	durationHistory map[string]*durationHistogram
+	errorCount      map[string]int64
+	emitFailures    int64
+}
+
+/*
+  durationHistogramBoundsMs are the upper bound, in milliseconds, of each bucket in a
+  durationHistogram, Prometheus-style: bucket i counts every sample <= bounds[i], plus one
+  final overflow bucket for anything slower than the last bound.
+*/
+var durationHistogramBoundsMs = []int64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+/*
+  durationHistogram counts query durations per command into durationHistogramBoundsMs buckets,
+  so an operator can tell a command that's consistently slow from one that only occasionally
+  spikes, rather than only ever seeing its most recent duration.
+*/
+type durationHistogram struct {
+	bucketCounts []int64 // len(durationHistogramBoundsMs)+1, last slot is the overflow bucket
+	count        int64
+	sumMs        int64
+}
+
+func newDurationHistogram() *durationHistogram {
+	return &durationHistogram{bucketCounts: make([]int64, len(durationHistogramBoundsMs)+1)}
+}
+
+func (d *durationHistogram) observe(duration time.Duration) {
+	ms := int64(duration / time.Millisecond)
+	d.count++
+	d.sumMs += ms
+	for i, bound := range durationHistogramBoundsMs {
+		if ms <= bound {
+			d.bucketCounts[i]++
+		}
+	}
+	if ms > durationHistogramBoundsMs[len(durationHistogramBoundsMs)-1] {
+		d.bucketCounts[len(d.bucketCounts)-1]++
+	}
+}
+
+func newHealthState() *healthState {
+	return &healthState{
+		lastSuccess:     make(map[string]time.Time),
+		lastDuration:    make(map[string]time.Duration),
+		durationHistory: make(map[string]*durationHistogram),
+		errorCount:      make(map[string]int64),
+	}
+}
+
+/*
+  setReady flips readiness once, typically right after every configured mysql target has
+  connected at startup; it's what distinguishes /readyz (dependencies up) from /healthz
+  (process alive).
+*/
+func (h *healthState) setReady(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+}
+
+func (h *healthState) isReady() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+/*
+  recordQuery is called once per command per tick (covering every row for a templated,
+  multi-row query) with how long it took and whether it ultimately succeeded.
+*/
+func (h *healthState) recordQuery(key string, duration time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastDuration[key] = duration
+	histogram, ok := h.durationHistory[key]
+	if !ok {
+		histogram = newDurationHistogram()
+		h.durationHistory[key] = histogram
+	}
+	histogram.observe(duration)
+	if err != nil {
+		h.errorCount[key]++
+		return
+	}
+	h.lastSuccess[key] = time.Now()
+}
+
+func (h *healthState) recordEmitFailure() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.emitFailures++
+}
+
+/*
+  durationHistogramSnapshot is the JSON shape of one command's durationHistogram: cumulative
+  bucket counts keyed by each bucket's upper bound in milliseconds ("+Inf" for the overflow
+  bucket), plus the sample count and sum needed to derive an average alongside the buckets.
+*/
+type durationHistogramSnapshot struct {
+	BucketsMs map[string]int64 `json:"buckets_ms"`
+	Count     int64            `json:"count"`
+	SumMs     int64            `json:"sum_ms"`
+}
+
+func (d *durationHistogram) snapshot() durationHistogramSnapshot {
+	buckets := make(map[string]int64, len(d.bucketCounts))
+	for i, bound := range durationHistogramBoundsMs {
+		buckets[strconv.FormatInt(bound, 10)] = d.bucketCounts[i]
+	}
+	buckets["+Inf"] = d.count
+	return durationHistogramSnapshot{BucketsMs: buckets, Count: d.count, SumMs: d.sumMs}
+}
+
+/*
+  healthSnapshot is the JSON shape served at /debug/vars: alongside the last-seen latency per
+  command, DurationHistogram reports the full distribution so an operator can tell a
+  consistently slow command from one that only occasionally spikes.
+*/
+type healthSnapshot struct {
+	Ready             bool                                 `json:"ready"`
+	LastSuccess       map[string]int64                     `json:"last_success_unix"`
+	LastDurationMs    map[string]int64                     `json:"last_duration_ms"`
+	DurationHistogram map[string]durationHistogramSnapshot `json:"duration_histogram"`
+	ErrorCount        map[string]int64                     `json:"error_count"`
+	EmitFailures      int64                                `json:"emit_failures"`
+}
+
+func (h *healthState) snapshot() healthSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lastSuccess := make(map[string]int64, len(h.lastSuccess))
+	for key, at := range h.lastSuccess {
+		lastSuccess[key] = at.Unix()
+	}
+	lastDurationMs := make(map[string]int64, len(h.lastDuration))
+	for key, d := range h.lastDuration {
+		lastDurationMs[key] = int64(d / time.Millisecond)
+	}
+	durationHistogram := make(map[string]durationHistogramSnapshot, len(h.durationHistory))
+	for key, histogram := range h.durationHistory {
+		durationHistogram[key] = histogram.snapshot()
+	}
+	errorCount := make(map[string]int64, len(h.errorCount))
+	for key, count := range h.errorCount {
+		errorCount[key] = count
+	}
+	return healthSnapshot{
+		Ready:             h.ready,
+		LastSuccess:       lastSuccess,
+		LastDurationMs:    lastDurationMs,
+		DurationHistogram: durationHistogram,
+		ErrorCount:        errorCount,
+		EmitFailures:      h.emitFailures,
+	}
+}
+
+/*
+  newAdminServer builds Mambo's optional admin HTTP server: /healthz for liveness, /readyz for
+  readiness, /debug/pprof/* for profiling a wedged process, and /debug/vars for a JSON
+  snapshot of healthState. It's entirely separate from the prometheus sink's own /metrics
+  listener, which only exists when sink = prometheus is configured.
+*/
+func newAdminServer(addr string, health *healthState) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !health.isReady() {
+			http.Error(w, "not ready\n", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health.snapshot())
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error(err.Error())
+		}
+	}()
+	return server
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json" // stdout/JSON debug sink
+	"fmt"           // Output formatting
+	"net"           // Graphite TCP connection
+	"net/http"      // Prometheus /metrics endpoint
+	"sort"          // stable ordering of the prometheus exposition output
+	"strconv"       // string conversion
+	"strings"       // string manipulation
+	"sync"          // guarding shared emitter state
+	"time"          // graphite timestamps
+
+	"github.com/cactus/go-statsd-client/statsd" // Statsd client
+)
+
+/*
+  Emitter is anything mambo can hand a metric to. statsd is the original behavior; prometheus,
+  graphite and stdout let a [config] (or a single command via its "sink" override) point
+  elsewhere without touching the MySQL side of things.
+*/
+type Emitter interface {
+	Emit(m metric) error
+	Close() error
+}
+
+/*
+  buildEmitters constructs exactly the Emitters referenced by commands (falling back to the
+  [config] default sink for commands that don't override it), so an unused sink never opens a
+  socket or starts a listener.
+*/
+func buildEmitters(config *configuration, commands []command) map[string]Emitter {
+	needed := make(map[string]bool)
+	for _, cmd := range commands {
+		needed[cmd.sink] = true
+	}
+	emitters := make(map[string]Emitter)
+	for sink := range needed {
+		emitter, err := newEmitter(sink, config)
+		if err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		emitters[sink] = emitter
+	}
+	return emitters
+}
+
+/*
+  closeEmitters closes every Emitter mambo built, logging (rather than failing on) any error
+  since this only ever runs during shutdown.
+*/
+func closeEmitters(emitters map[string]Emitter) {
+	for _, emitter := range emitters {
+		if err := emitter.Close(); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+func newEmitter(sink string, config *configuration) (Emitter, error) {
+	switch sink {
+	case "prometheus":
+		return newPrometheusEmitter(config)
+	case "graphite":
+		return newGraphiteEmitter(config)
+	case "stdout":
+		return newStdoutEmitter(), nil
+	default: // "statsd", and anything unrecognized, keeps the original behavior
+		return newStatsdEmitter(config)
+	}
+}
+
+/*
+  statsdEmitter holds a single long-lived statsd.Client and dispatches to the method matching
+  m.metricType. Tags are a DogStatsD extension the underlying client doesn't model natively,
+  so tagged (and histogram) metrics are sent through Raw with a hand-built
+  "value|type|#tags" payload instead of one of the typed helpers.
+*/
+type statsdEmitter struct {
+	client statsd.Statter
+}
+
+func newStatsdEmitter(config *configuration) (*statsdEmitter, error) {
+	client, err := statsd.NewClient(statsdURIBuilder(config), "")
+	if err != nil {
+		return nil, err
+	}
+	return &statsdEmitter{client: client}, nil
+}
+
+func (e *statsdEmitter) Emit(m metric) error {
+	rate := float32(m.sampleRate)
+	if rate == 0 {
+		rate = 1.0
+	}
+
+	if len(m.tags) > 0 || m.metricType == "histogram" {
+		return e.client.Raw(m.key, dogstatsdPayload(m), rate)
+	}
+	switch m.metricType {
+	case "gauge":
+		return e.client.Gauge(m.key, m.value, rate)
+	case "timing":
+		return e.client.TimingDuration(m.key, time.Duration(m.value)*time.Millisecond, rate)
+	case "set":
+		return e.client.Set(m.key, strconv.FormatInt(m.value, 10), rate)
+	default: // "counter", and anything unrecognized, keeps the original Inc behavior
+		return e.client.Inc(m.key, m.value, rate)
+	}
+}
+
+func (e *statsdEmitter) Close() error {
+	return e.client.Close()
+}
+
+/*
+  Builds up the statsd connect uri from statsdHost and statsdPort parameters.
+  For example: statsdHost = graph, statsdPort = 8125 -> url:"graph:8125"
+*/
+func statsdURIBuilder(config *configuration) string {
+	return fmt.Sprint(config.statsdHost, ":", config.statsdPort)
+}
+
+/*
+  dogstatsdPayload builds the "value|type[|#tag,tag]" suffix Raw expects, for metrics that
+  need the histogram type or tags the typed Statter methods can't express.
+*/
+func dogstatsdPayload(m metric) string {
+	payload := fmt.Sprint(m.value, "|", statsdTypeSuffix(m.metricType))
+	if len(m.tags) > 0 {
+		payload = fmt.Sprint(payload, "|#", strings.Join(m.tags, ","))
+	}
+	return payload
+}
+
+/*
+  statsdTypeSuffix maps a command's metricType to the wire-format statsd type suffix.
+*/
+func statsdTypeSuffix(metricType string) string {
+	switch metricType {
+	case "gauge":
+		return "g"
+	case "timing":
+		return "ms"
+	case "set":
+		return "s"
+	case "histogram":
+		return "h"
+	default:
+		return "c"
+	}
+}
+
+/*
+  prometheusEmitter keeps the latest value seen for each key and serves them on /metrics in
+  Prometheus exposition format, registering gauges/counters lazily as commands report them.
+*/
+type prometheusEmitter struct {
+	mu      sync.Mutex
+	samples map[string]prometheusSample
+	server  *http.Server
+}
+
+type prometheusSample struct {
+	value      int64
+	metricType string
+}
+
+func newPrometheusEmitter(config *configuration) (*prometheusEmitter, error) {
+	addr := config.prometheusListenAddr
+	if addr == "" {
+		addr = ":9112"
+	}
+	e := &prometheusEmitter{samples: make(map[string]prometheusSample)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.handleMetrics)
+	e.server = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := e.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error(err.Error())
+		}
+	}()
+	return e, nil
+}
+
+func (e *prometheusEmitter) Emit(m metric) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.samples[prometheusName(m.key)] = prometheusSample{value: m.value, metricType: m.metricType}
+	return nil
+}
+
+func (e *prometheusEmitter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	names := make([]string, 0, len(e.samples))
+	for name := range e.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sample := e.samples[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", name, prometheusType(sample.metricType), name, sample.value)
+	}
+}
+
+func (e *prometheusEmitter) Close() error {
+	return e.server.Close()
+}
+
+/*
+  prometheusName translates a statsd-style dotted key into Prometheus' underscore
+  convention, eg. "mysql.repl.lag" -> "mysql_repl_lag".
+*/
+func prometheusName(key string) string {
+	return strings.Replace(key, ".", "_", -1)
+}
+
+/*
+  prometheusType maps a command's metricType onto one of the two Prometheus metric kinds
+  Mambo knows how to expose; counters stay counters, everything else (gauge, timing, set,
+  histogram) is exposed as a gauge since Prometheus has no statsd-shaped equivalent for those.
+*/
+func prometheusType(metricType string) string {
+	if metricType == "counter" {
+		return "counter"
+	}
+	return "gauge"
+}
+
+/*
+  graphiteEmitter writes the Graphite plaintext protocol ("path value timestamp\n") over a
+  single persistent TCP connection, reconnecting once if a write finds it gone stale.
+*/
+type graphiteEmitter struct {
+	mu   sync.Mutex
+	conn net.Conn
+	addr string
+}
+
+func newGraphiteEmitter(config *configuration) (*graphiteEmitter, error) {
+	addr := fmt.Sprint(config.graphiteHost, ":", config.graphitePort)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &graphiteEmitter{conn: conn, addr: addr}, nil
+}
+
+func (e *graphiteEmitter) Emit(m metric) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	line := fmt.Sprintf("%s %d %d\n", m.key, m.value, time.Now().Unix())
+	if _, err := e.conn.Write([]byte(line)); err != nil {
+		conn, dialErr := net.Dial("tcp", e.addr)
+		if dialErr != nil {
+			return err
+		}
+		e.conn = conn
+		_, err = e.conn.Write([]byte(line))
+		return err
+	}
+	return nil
+}
+
+func (e *graphiteEmitter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.conn.Close()
+}
+
+/*
+  stdoutEmitter prints every metric as a JSON line, for debugging a config without standing
+  up a real statsd/Prometheus/Graphite backend.
+*/
+type stdoutEmitter struct{}
+
+func newStdoutEmitter() *stdoutEmitter {
+	return &stdoutEmitter{}
+}
+
+func (e *stdoutEmitter) Emit(m metric) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"key":         m.key,
+		"value":       m.value,
+		"type":        m.metricType,
+		"tags":        m.tags,
+		"sample_rate": m.sampleRate,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(payload))
+	return nil
+}
+
+func (e *stdoutEmitter) Close() error {
+	return nil
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
-	"database/sql" // MySQL Query
-	"flag"         // Command line parsing
-	"fmt"          // Output formatting
-	"os"           // to exit with exitcode
-	"strconv"      // string conversion
-	"strings"      // string manipulation
-	"time"         // timestamp logging, ticker
-
-	"github.com/cactus/go-statsd-client/statsd" // Statsd client
-	_ "github.com/go-sql-driver/mysql"          // MySQL connection
-	"github.com/koding/logging"                 // logging
-	"gopkg.in/ini.v1"                           // ini file parsing
+	"bytes"         // key template rendering buffer
+	"context"       // cancellation propagated from shutdown signals into every controller/worker
+	"crypto/tls"    // custom TLS config for MySQL connections
+	"crypto/x509"   // CA cert pool for MySQL TLS
+	"database/sql"  // MySQL Query
+	"flag"          // Command line parsing
+	"fmt"           // Output formatting
+	"io/ioutil"     // reading TLS cert/key files
+	"net/http"      // admin server
+	"os"            // to exit with exitcode
+	"os/signal"     // SIGINT/SIGTERM handling for graceful shutdown
+	"sort"          // deterministic ordering when a command targets "*"
+	"strconv"       // string conversion
+	"strings"       // string manipulation
+	"sync"          // guarding the prepared statement cache, waiting for workers to drain
+	"syscall"       // SIGTERM
+	"text/template" // key_template rendering
+	"time"          // timestamp logging, ticker
+
+	"github.com/go-sql-driver/mysql" // MySQL connection
+	"github.com/koding/logging"      // logging
+	"gopkg.in/ini.v1"                // ini file parsing
 )
 
 var logger = logging.NewLogger("Mambo")
@@ -21,22 +31,72 @@ var logger = logging.NewLogger("Mambo")
   Configuration parameters, mysql & statsd
 */
 type configuration struct {
-	mysqlHost  string // MySQL host to connect, if empty local socket will be used
-	mysqlUser  string // User to connect MySQL with
-	mysqlPass  string // Password for connecting MySQL
-	mysqlDb    string // Database to connect to
-	mysqlPort  int    // Port to connect MySQL, if left blank, 3306 will be used as default
-	statsdHost string // statsd server hostname
-	statsdPort int    // statsd server port, if left blank, 8125 will be used as default
+	mysqlTargets         map[string]mysqlTargetConfig // MySQL targets to poll, keyed by alias; "default" holds the legacy single-target [config] fields
+	statsdHost           string                       // statsd server hostname
+	statsdPort           int                           // statsd server port, if left blank, 8125 will be used as default
+	sink                 string                        // default Emitter to send metrics to: statsd (default) | prometheus | graphite | stdout
+	prometheusListenAddr string                        // address the prometheus sink's /metrics endpoint binds to, defaults to ":9112"
+	graphiteHost         string                        // graphite plaintext protocol host
+	graphitePort         int                           // graphite plaintext protocol port
+	adminListenAddr      string                        // address the admin server (/healthz, /readyz, pprof) binds to; disabled when empty
+}
+
+/*
+  mysqlTargetConfig holds everything needed to open a pooled connection to one MySQL
+  target. It used to be a handful of top-level configuration fields, but monitoring
+  a whole replication topology from one Mambo process means there can be several of
+  these, one per "[mysql \"alias\"]" section, keyed by alias.
+*/
+type mysqlTargetConfig struct {
+	host            string // MySQL host to connect, if empty local socket will be used
+	user            string // User to connect MySQL with
+	pass            string // Password for connecting MySQL
+	db              string // Database to connect to
+	port            int    // Port to connect MySQL, if left blank, 3306 will be used as default
+	socket          string // Unix socket path to connect through, takes precedence over host/port
+	defaultsFile    string // path to a MySQL defaults file (~/.my.cnf style) holding a [client] user/password
+	maxOpenConns    int    // max open connections in the pool, defaults to 10
+	maxIdleConns    int    // max idle connections kept around, defaults to 5
+	connMaxLifetime int    // seconds a pooled connection may be reused for, defaults to 300
+	tlsCa           string // CA cert used to verify the MySQL server, enables TLS when set
+	tlsCert         string // client cert for MySQL TLS, optional
+	tlsKey          string // client key for MySQL TLS, optional
 }
 
 /*
   Commands
 */
 type command struct {
-	key   string // key to send statsd server (eg. mysql.slave01.bfc.kinja-ops.com.replication lag)
-	query string // query to run against mysql server. The output must be an integer
-	freq  int    // what frequency the query should be run in milliseconds
+	key          string        // key to send statsd server (eg. mysql.slave01.bfc.kinja-ops.com.replication lag)
+	query        string        // query to run against mysql server. The output must be an integer
+	queryArgs    []interface{} // placeholder args bound positionally into query, eg. a performance_schema row key
+	freq         int           // what frequency the query should be run in milliseconds
+	source       string        // where the command comes from: "query" (default) or "performance_schema"
+	table        string        // performance_schema table/view to introspect when source is "performance_schema"
+	keyColumn    string        // column whose value identifies each row and gets interpolated into key
+	valueColumn  string        // column whose value is reported as the metric
+	keyTemplate  string        // text/template over column names, eg. "mysql.repl.{{.channel_name}}.seconds_behind", enables the multi-row query path
+	valueColumns []string      // columns to emit as metrics when keyTemplate is set; more than one appends the column name to the rendered key
+	metricType   string        // statsd verb to send as: counter|gauge|timing|set|histogram, defaults to counter
+	sampleRate   float64       // statsd sample rate, defaults to 1.0 (always sent)
+	tags         []string      // DogStatsD-style "k:v" tags sent alongside the metric
+	sink         string        // which Emitter to send this command's metrics to, overrides configuration.sink
+	targets      []string      // mysql target aliases to fan this command out to, "*" means every configured target
+	targetAlias  string        // the single target alias this (already expanded) command instance runs against
+}
+
+/*
+  metric is what a mysqlWorker/runTemplatedQuery pushes onto the results channel: a typed,
+  structured payload rather than a stringly-typed "key:value" that broke on any key or value
+  containing a colon.
+*/
+type metric struct {
+	key        string
+	value      int64
+	metricType string
+	tags       []string
+	sampleRate float64
+	sink       string // which Emitter this metric should be routed to
 }
 
 func main() {
@@ -46,19 +106,89 @@ func main() {
 	logger := logging.NewLogger("Mambo")
 	logger.Notice("Mambo collector started")
 	logger.Notice("Loading configuration from %s", *configfile)
+
+	// ctx is canceled on SIGINT/SIGTERM and propagated into every controller/mysqlWorker so they
+	// stop picking up new ticks and let any in-flight query finish (or abandon it) instead of
+	// being killed mid-query.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Notice("received %s, draining workers before shutdown", sig)
+		cancel()
+	}()
+
+	health := newHealthState()
+
 	// The 'results' channel will recive the results of the mysqlWorker queries
-	results := make(chan string)
+	results := make(chan metric)
 	config, commands := configure(*configfile) // Loading configuration and commands from ini file
-	for _, command := range commands {
-		go controller(command, config, results) // every command will launch a command controller
+	targets := make(map[string]*mysqlTarget)    // one pooled connection per mysql target alias
+	for alias, targetConfig := range config.mysqlTargets {
+		target, err := newMysqlTarget(targetConfig)
+		if err != nil {
+			logger.Critical(err.Error())
+			os.Exit(1)
+		}
+		defer target.db.Close()
+		targets[alias] = target
+	}
+	health.setReady(true) // every configured mysql target connected; Mambo can now serve /readyz
+
+	emitters := buildEmitters(config, commands) // One Emitter per distinct sink referenced by the commands
+	defer closeEmitters(emitters)
+
+	var adminServer *http.Server
+	if config.adminListenAddr != "" {
+		adminServer = newAdminServer(config.adminListenAddr, health)
+	}
+
+	var emits sync.WaitGroup
+	var workers sync.WaitGroup
+	for _, cmd := range commands {
+		target, ok := targets[cmd.targetAlias]
+		if !ok {
+			logger.Error("no mysql target configured for alias %s, skipping %s", cmd.targetAlias, cmd.key)
+			continue
+		}
+		workers.Add(1)
+		go func(cmd command, target *mysqlTarget) {
+			defer workers.Done()
+			controller(ctx, cmd, target, results, health) // every command will launch a command controller
+		}(cmd, target)
 	}
 	logger.Notice("Data collector running")
 	for {
 		select {
-		// every time a MySQL worker yield data to the 'results' channel we call a statsdSender and we send that data to statsdserver
-		case msg := <-results:
+		case <-ctx.Done():
+			// every mysqlWorker send is itself select{results<-m; <-ctx.Done()}, so it's safe to
+			// stop draining results here: a worker racing the same shutdown just drops its metric
+			// instead of blocking forever on a channel nobody reads anymore.
+			workers.Wait()
+			emits.Wait()
+			if adminServer != nil {
+				adminServer.Close()
+			}
+			logger.Notice("all workers drained, exiting")
+			return
+		// every metric is handed to its sink's Emitter in its own goroutine, so a slow
+		// Prometheus scrape or statsd flush can't stall the channel and block mysqlWorkers
+		case m := <-results:
 			{
-				statsdSender(config, msg)
+				emitter, ok := emitters[m.sink]
+				if !ok {
+					logger.Error("no emitter configured for sink %s", m.sink)
+					continue
+				}
+				emits.Add(1)
+				go func(e Emitter, m metric) {
+					defer emits.Done()
+					if err := e.Emit(m); err != nil {
+						logger.Error(err.Error())
+						health.recordEmitFailure()
+					}
+				}(emitter, m)
 			}
 		}
 	}
@@ -67,96 +197,397 @@ func main() {
 /*
   The controller reads the command frequency (rate) from the command, and sets up
   a ticker with that frequency. We wait for the tick, and when it happens, we call
-  a mysqlWorker with the command
+  a mysqlWorker with the command. ctx.Done() stops the loop from picking up further
+  ticks once mambo is shutting down.
 */
-func controller(cmd command, cnf *configuration, results chan string) {
+func controller(ctx context.Context, cmd command, target *mysqlTarget, results chan metric, health *healthState) {
 	logger.Notice("Query loaded: %s", cmd.query)
-	tick := time.NewTicker(time.Millisecond * time.Duration(cmd.freq)).C // I have to convert freq to time.Duration to use with ticker
+	ticker := time.NewTicker(time.Millisecond * time.Duration(cmd.freq)) // I have to convert freq to time.Duration to use with ticker
+	defer ticker.Stop()
 	for {
 		select {
-		case <-tick:
-			mysqlWorker(cnf, cmd, results)
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mysqlWorker(ctx, target, cmd, results, health)
 		}
 	}
 }
 
 /*
-  Builds up the statsd connect uri from
-  statsdHost and statsdPort parameters
-  For example:
-  statsdHost = graphstatsdPort = 8125 -> url:"graph:8125"
+  sendMetric delivers m to results, but backs off onto ctx.Done() instead of blocking forever
+  if mambo is shutting down and nothing is draining results anymore - the metric is dropped in
+  that case, favoring a clean exit over guaranteed delivery of the last in-flight tick.
 */
-func statsdURIBuilder(config *configuration) string {
-	uri := fmt.Sprint(config.statsdHost, ":", config.statsdPort)
-	return uri
+func sendMetric(ctx context.Context, results chan metric, m metric) {
+	select {
+	case results <- m:
+	case <-ctx.Done():
+	}
+}
 
+/*
+  mysqlTarget wraps a single pooled *sql.DB together with a cache of prepared statements,
+  keyed by query text, so repeated ticks of the same command reuse both the connection and
+  the prepared statement instead of paying handshake/parse cost on every tick.
+*/
+type mysqlTarget struct {
+	db        *sql.DB
+	stmtCache map[string]*sql.Stmt
+	cacheMu   sync.Mutex
 }
 
 /*
-  Connects statsd server and sends the metric
+  newMysqlTarget opens the pooled connection for a target once at startup, applying
+  defaults-file auth and the configured pool limits/TLS settings.
 */
-func statsdSender(config *configuration, msg string) {
-	client, err := statsd.NewClient(statsdURIBuilder(config), "")
+func newMysqlTarget(target mysqlTargetConfig) (*mysqlTarget, error) {
+	connecturi, err := mysqlURIBuilder(target)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("mysql", connecturi)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	maxOpenConns := target.maxOpenConns
+	if maxOpenConns == 0 {
+		maxOpenConns = 10
+	}
+	maxIdleConns := target.maxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = 5
+	}
+	connMaxLifetime := target.connMaxLifetime
+	if connMaxLifetime == 0 {
+		connMaxLifetime = 300
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(connMaxLifetime) * time.Second)
+
+	return &mysqlTarget{db: db, stmtCache: make(map[string]*sql.Stmt)}, nil
+}
+
+/*
+  prepare returns a cached *sql.Stmt for query, preparing and caching it on first use.
+*/
+func (target *mysqlTarget) prepare(query string) (*sql.Stmt, error) {
+	target.cacheMu.Lock()
+	defer target.cacheMu.Unlock()
+	if stmt, ok := target.stmtCache[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := target.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	target.stmtCache[query] = stmt
+	return stmt, nil
+}
+
+/*
+  The mysqlWorker function runs the query which came from the command against the shared
+  pooled connection and puts the result to the results channel. Commands with a keyTemplate
+  are fanned out to runTemplatedQuery, everything else keeps the original single-scalar
+  behavior, now backed by a cached prepared statement instead of a fresh one per tick. Every
+  run is timed and recorded on health, so /debug/vars on the admin server can show whether a
+  stalled metric means a slow query, a MySQL error, or Mambo itself being wedged.
+*/
+func mysqlWorker(ctx context.Context, target *mysqlTarget, cmd command, results chan metric, health *healthState) {
+	start := time.Now()
+	if cmd.keyTemplate != "" {
+		runTemplatedQuery(ctx, target.db, cmd, results, health)
+		return
+	}
+
+	var result string
+	stmtOut, err := target.prepare(cmd.query)
 	if err != nil {
 		logger.Error(err.Error())
+		health.recordQuery(cmd.key, time.Since(start), err)
+		sendMetric(ctx, results, metric{key: fmt.Sprint("mambo.query.", cmd.key, ".error"), value: 1, metricType: "counter", sampleRate: 1.0, sink: cmd.sink})
+		return
 	}
-	defer client.Close()
-	arr := strings.Split(msg, ":")
-	key := arr[0]
-	value, err := strconv.ParseInt(arr[1], 10, 64)
+	err = stmtOut.QueryRow(cmd.queryArgs...).Scan(&result)
 	if err != nil {
 		logger.Error(err.Error())
+		health.recordQuery(cmd.key, time.Since(start), err)
+		sendMetric(ctx, results, metric{key: fmt.Sprint("mambo.query.", cmd.key, ".error"), value: 1, metricType: "counter", sampleRate: 1.0, sink: cmd.sink})
+		return
 	}
-	//	logger.Info("Statsd data flushed: %s", msg)
-	err = client.Inc(key, value, 1.0)
+	value, err := strconv.ParseInt(result, 10, 64)
 	if err != nil {
 		logger.Error(err.Error())
+		health.recordQuery(cmd.key, time.Since(start), err)
+		sendMetric(ctx, results, metric{key: fmt.Sprint("mambo.query.", cmd.key, ".error"), value: 1, metricType: "counter", sampleRate: 1.0, sink: cmd.sink})
+		return
 	}
+	health.recordQuery(cmd.key, time.Since(start), nil)
+	//	logger.Info("Data recieved from MySQL server: %s:%d", cmd.key, value)
+	sendMetric(ctx, results, metric{key: cmd.key, value: value, metricType: cmd.metricType, tags: cmd.tags, sampleRate: cmd.sampleRate, sink: cmd.sink})
 }
 
 /*
-  The mysqlWorker function connects to the database, runs the query which came from the command
-  and puts the result to the results channel
+  renderTemplatedKey executes tmpl against row (one scanned result row, column name -> value)
+  and returns the rendered statsd key. Split out of runTemplatedQuery so the rendering logic
+  can be exercised without a live *sql.DB.
 */
-func mysqlWorker(config *configuration, cmd command, results chan string) {
-	var result string
-	connecturi := mysqlURIBuilder(config)
-	db, err := sql.Open("mysql", connecturi)
+func renderTemplatedKey(tmpl *template.Template, row map[string]interface{}) (string, error) {
+	var keyBuf bytes.Buffer
+	if err := tmpl.Execute(&keyBuf, row); err != nil {
+		return "", err
+	}
+	return keyBuf.String(), nil
+}
+
+/*
+  runTemplatedQuery runs cmd.query expecting any number of rows and columns. For every row it
+  renders cmd.keyTemplate (a text/template executed against a map of column name -> value) to
+  build the statsd key, then emits one metric per column listed in cmd.valueColumns. This
+  is what lets a single command cover things like SHOW SLAVE STATUS or per-schema breakdowns
+  instead of only ever returning one scalar. Scan or template failures push a
+  "mambo.query.<key>.error" counter instead of silently dropping the row. The whole run is
+  timed and recorded on health as a single command-level result, even though it may cover
+  many rows.
+*/
+func runTemplatedQuery(ctx context.Context, db *sql.DB, cmd command, results chan metric, health *healthState) {
+	start := time.Now()
+	failed := false
+	queryErrorMetric := metric{key: fmt.Sprint("mambo.query.", cmd.key, ".error"), value: 1, metricType: "counter", sampleRate: 1.0, sink: cmd.sink}
+
+	tmpl, err := template.New(cmd.key).Parse(cmd.keyTemplate)
 	if err != nil {
 		logger.Error(err.Error())
+		health.recordQuery(cmd.key, time.Since(start), err)
+		sendMetric(ctx, results, queryErrorMetric)
+		return
 	}
-	defer db.Close()
-	err = db.Ping()
+
+	rows, err := db.Query(cmd.query)
 	if err != nil {
 		logger.Error(err.Error())
+		health.recordQuery(cmd.key, time.Since(start), err)
+		sendMetric(ctx, results, queryErrorMetric)
+		return
 	}
-	stmtOut, err := db.Prepare(cmd.query)
+	defer rows.Close()
+
+	cols, err := rows.Columns()
 	if err != nil {
 		logger.Error(err.Error())
+		health.recordQuery(cmd.key, time.Since(start), err)
+		sendMetric(ctx, results, queryErrorMetric)
+		return
 	}
-	defer stmtOut.Close()
-	err = stmtOut.QueryRow().Scan(&result)
+
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		valuePtrs := make([]interface{}, len(cols))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			logger.Error(err.Error())
+			failed = true
+			sendMetric(ctx, results, queryErrorMetric)
+			continue
+		}
+
+		row := make(map[string]interface{})
+		for i, col := range cols {
+			row[col] = scanValueToString(values[i])
+		}
+
+		key, err := renderTemplatedKey(tmpl, row)
+		if err != nil {
+			logger.Error(err.Error())
+			failed = true
+			sendMetric(ctx, results, queryErrorMetric)
+			continue
+		}
+
+		for _, valueColumn := range cmd.valueColumns {
+			raw, ok := row[valueColumn]
+			if !ok {
+				logger.Error("column %s not present in result set for query: %s", valueColumn, cmd.query)
+				failed = true
+				sendMetric(ctx, results, queryErrorMetric)
+				continue
+			}
+			value, err := strconv.ParseInt(fmt.Sprint(raw), 10, 64)
+			if err != nil {
+				logger.Error(err.Error())
+				failed = true
+				sendMetric(ctx, results, queryErrorMetric)
+				continue
+			}
+			metricKey := key
+			if len(cmd.valueColumns) > 1 {
+				metricKey = fmt.Sprint(key, ".", valueColumn)
+			}
+			sendMetric(ctx, results, metric{key: metricKey, value: value, metricType: cmd.metricType, tags: cmd.tags, sampleRate: cmd.sampleRate, sink: cmd.sink})
+		}
+	}
+
+	if failed {
+		health.recordQuery(cmd.key, time.Since(start), fmt.Errorf("one or more rows of %s failed to scan/template/parse", cmd.key))
+	} else {
+		health.recordQuery(cmd.key, time.Since(start), nil)
+	}
+}
+
+/*
+  scanValueToString normalizes a database/sql scan target: the MySQL driver hands back
+  []byte for most non-binary column types, so this turns those into plain strings and
+  leaves everything else (ints, floats, nil, ...) untouched.
+*/
+func scanValueToString(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+/*
+   Helper function to the mysqlWorker, it builds up the connect uri based on a mysql
+   target. If defaultsFile is set, user/pass are taken from its [client] section instead
+   of the target. If socket is set it takes precedence over host/port; otherwise, if no
+   host is given, it connects via the local socket, ignoring the port option. If tlsCa is
+   set, a custom TLS config is registered with the driver and appended to the DSN.
+*/
+func mysqlURIBuilder(target mysqlTargetConfig) (string, error) {
+	user := target.user
+	pass := target.pass
+	if target.defaultsFile != "" {
+		defaultsUser, defaultsPass, err := loadDefaultsFile(target.defaultsFile)
+		if err != nil {
+			return "", err
+		}
+		if defaultsUser != "" {
+			user = defaultsUser
+		}
+		if defaultsPass != "" {
+			pass = defaultsPass
+		}
+	}
+
+	tlsParam := ""
+	if target.tlsCa != "" {
+		if err := registerMysqlTLS(target); err != nil {
+			return "", err
+		}
+		tlsParam = "?tls=mambo"
+	}
+
+	var uri string
+	switch {
+	case target.socket != "": // a unix socket path always wins over host/port
+		uri = fmt.Sprint(user, ":", pass, "@unix(", target.socket, ")/", target.db, tlsParam)
+	case target.host == "": // if host is not defined, we'll connect through the local socket
+		uri = fmt.Sprint(user, ":", pass, "@", "/", target.db, tlsParam)
+	default: // if we use TCP we'll also need the port of mysql too
+		uri = fmt.Sprint(user, ":", pass, "@", target.host, ":", target.port, "/", target.db, tlsParam)
+	}
+	return uri, nil
+}
+
+/*
+  loadDefaultsFile reads user/password out of the [client] section of a MySQL
+  defaults file (~/.my.cnf style), the same auth mechanism pstop uses.
+*/
+func loadDefaultsFile(path string) (string, string, error) {
+	defaults, err := ini.Load(path)
 	if err != nil {
-		logger.Error(err.Error())
+		return "", "", err
 	}
-	res := fmt.Sprint(cmd.key, ":", result)
-	//	logger.Info("Data recieved from MySQL server: %s", res)
-	results <- res
+	client := defaults.Section("client")
+	return client.Key("user").String(), client.Key("password").String(), nil
 }
 
 /*
-   Helper function to the mysqlWorker, it builds up the connect uri based on config
-   if no mysqlHost is given, it tries to connect via local socket, and ignores the
-   mysqlPort option.
+  registerMysqlTLS builds a tls.Config from the configured CA (and optional client
+  cert/key) and registers it with the MySQL driver under the name "mambo", so the
+  DSN can opt in via "?tls=mambo". Since mysql.RegisterTLSConfig is a single global
+  registry, this only supports one CA across all targets at a time - the last
+  target to connect wins the "mambo" registration for everyone else. Fine while
+  most setups share a CA; targets needing distinct CAs will need a per-alias name.
 */
-func mysqlURIBuilder(config *configuration) string {
-	uri := ""
-	if config.mysqlHost == "" { // if mysqlHost is not defined, we'll connect through local socket
-		uri = fmt.Sprint(config.mysqlUser, ":", config.mysqlPass, "@", "/", config.mysqlDb)
-	} else { // if we use TCP we'll also need the port of mysql too
-		uri = fmt.Sprint(config.mysqlUser, ":", config.mysqlPass, "@", config.mysqlHost, ":", config.mysqlPort, "/", config.mysqlDb)
+func registerMysqlTLS(target mysqlTargetConfig) error {
+	rootCertPool := x509.NewCertPool()
+	pem, err := ioutil.ReadFile(target.tlsCa)
+	if err != nil {
+		return err
+	}
+	if ok := rootCertPool.AppendCertsFromPEM(pem); !ok {
+		return fmt.Errorf("failed to append PEM certificates from %s", target.tlsCa)
+	}
+	tlsConfig := &tls.Config{RootCAs: rootCertPool}
+	if target.tlsCert != "" && target.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(target.tlsCert, target.tlsKey)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return mysql.RegisterTLSConfig("mambo", tlsConfig)
+}
+
+/*
+  expandPerformanceSchemaCommand introspects a performance_schema table/view
+  named in tmpl.table and turns it into one command per row, templatizing
+  tmpl.key by replacing the placeholder "<KEY_COLUMN>" (keyColumn upper-cased)
+  with that row's value, and tmpl.query into a single-row lookup against
+  valueColumn for that row, binding the row's key as a queryArgs placeholder
+  rather than splicing it into the SQL text. This lets a single config stanza
+  like "source = performance_schema" expand into dozens of scalar commands,
+  eg. one per row of events_statements_summary_by_digest.
+*/
+func expandPerformanceSchemaCommand(target mysqlTargetConfig, tmpl command) ([]command, error) {
+	connecturi, err := mysqlURIBuilder(target)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("mysql", connecturi)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	selectQuery := fmt.Sprintf("SELECT %s, %s FROM performance_schema.%s", tmpl.keyColumn, tmpl.valueColumn, tmpl.table)
+	rows, err := db.Query(selectQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	placeholder := fmt.Sprint("<", strings.ToUpper(tmpl.keyColumn), ">")
+	var expanded []command
+	for rows.Next() {
+		var rowKey, rowValue string
+		if err := rows.Scan(&rowKey, &rowValue); err != nil {
+			logger.Error(err.Error())
+			continue
+		}
+		expanded = append(expanded, command{
+			key:         strings.Replace(tmpl.key, placeholder, rowKey, 1),
+			query:       fmt.Sprintf("SELECT %s FROM performance_schema.%s WHERE %s = ?", tmpl.valueColumn, tmpl.table, tmpl.keyColumn),
+			queryArgs:   []interface{}{rowKey},
+			freq:        tmpl.freq,
+			metricType:  tmpl.metricType,
+			sampleRate:  tmpl.sampleRate,
+			tags:        tmpl.tags,
+			sink:        tmpl.sink,
+			targets:     tmpl.targets,
+			targetAlias: tmpl.targetAlias,
+		})
 	}
-	return uri
+	return expanded, rows.Err()
 }
 
 /*
@@ -165,8 +596,8 @@ func mysqlURIBuilder(config *configuration) string {
   assumes, that every other section will hold commands.
 */
 func configure(cfgfile string) (*configuration, []command) {
-	var mysqlPortc, statsdPortc int
-	var cfg configuration
+	var statsdPortc int
+	cfg := configuration{mysqlTargets: make(map[string]mysqlTargetConfig)}
 	//commands := make([]command, 0)
 	var commands []command
 	config, err := ini.Load(cfgfile)
@@ -175,46 +606,225 @@ func configure(cfgfile string) (*configuration, []command) {
 		os.Exit(1)
 	}
 	sections := config.Sections()
+	hasMysqlAliasSections := false
+	for _, section := range sections {
+		if isMysqlTargetSection(section.Name()) {
+			hasMysqlAliasSections = true
+			break
+		}
+	}
 	for _, section := range sections {
 		if section.Name() != "DEFAULT" { //skip unnamed section
-			if section.Name() == "config" { //[config] holds the configuratuin
-				mysqlHostc := section.Key("mysql_host").String()
-				mysqlUserc := section.Key("mysql_user").String()
-				mysqlPassc := section.Key("mysql_pass").String()
-				mysqlDbc := section.Key("mysql_db").String()
-				// if mysqlPort is not defined, we'll assume that the default 3306 will be used
-				mysqlPortc, err = section.Key("mysql_port").Int()
-				if mysqlPortc == 0 {
-					mysqlPortc = 3306
-				}
+			switch {
+			case section.Name() == "config": //[config] holds the configuration, plus the legacy single "default" mysql target
+				graphitePortc, _ := section.Key("graphite_port").Int()
 				statsdHostc := section.Key("statsd_host").String()
 				// if statsdPort is not defined, we'll assume that the default 8125 will be used
 				statsdPortc, err = section.Key("stats_port").Int()
 				if statsdPortc == 0 {
 					statsdPortc = 8125
 				}
-				cfg = configuration{
-					mysqlHost:  mysqlHostc,
-					mysqlUser:  mysqlUserc,
-					mysqlPass:  mysqlPassc,
-					mysqlPort:  mysqlPortc,
-					mysqlDb:    mysqlDbc,
-					statsdHost: statsdHostc,
-					statsdPort: statsdPortc,
+				sinkc := section.Key("sink").String()
+				if sinkc == "" { // if sink is not defined, we'll keep the original statsd behavior
+					sinkc = "statsd"
 				}
-			} else { // here start the command parsing
+				cfg.statsdHost = statsdHostc
+				cfg.statsdPort = statsdPortc
+				cfg.sink = sinkc
+				cfg.prometheusListenAddr = section.Key("prometheus_listen_addr").String()
+				cfg.graphiteHost = section.Key("graphite_host").String()
+				cfg.graphitePort = graphitePortc
+				cfg.adminListenAddr = section.Key("admin_listen_addr").String()
+				// promote the legacy single-target [config] keys into a "default" mysql target
+				// whenever they describe one (including the local-socket mode, where only
+				// mysql_user/mysql_db are set and mysql_host/mysql_socket are left blank), or
+				// whenever there are no [mysql "alias"] sections to fall back on at all —
+				// otherwise a pre-existing local-socket config silently ends up with zero
+				// targets and zero commands after upgrading to multi-target support.
+				if section.HasKey("mysql_host") || section.HasKey("mysql_socket") || section.HasKey("mysql_defaults_file") ||
+					section.HasKey("mysql_user") || section.HasKey("mysql_db") || !hasMysqlAliasSections {
+					cfg.mysqlTargets["default"] = parseMysqlTargetConfig(section, "mysql_")
+				}
+			case isMysqlTargetSection(section.Name()): //[mysql "alias"] holds one mysql target
+				alias := mysqlTargetAlias(section.Name())
+				cfg.mysqlTargets[alias] = parseMysqlTargetConfig(section, "")
+			default: // here start the command parsing
 				var cmd command
 				keyc := section.Key("key").String()
 				queryc := section.Key("query").String()
 				freqc, _ := section.Key("freq").Int()
+				sourcec := section.Key("source").String()
+				if sourcec == "" { // if source is not defined, we'll assume a plain query command
+					sourcec = "query"
+				}
+				var valueColumnsc []string
+				if raw := section.Key("value_columns").String(); raw != "" {
+					for _, col := range strings.Split(raw, ",") {
+						valueColumnsc = append(valueColumnsc, strings.TrimSpace(col))
+					}
+				} else if raw := section.Key("value_column").String(); raw != "" {
+					// the templated multi-row path also accepts the singular "value_column" key;
+					// without this, a key_template command that sets it (instead of the plural
+					// value_columns) silently emits no metrics at all.
+					valueColumnsc = []string{raw}
+				}
+				var tagsc []string
+				if raw := section.Key("tags").String(); raw != "" {
+					for _, tag := range strings.Split(raw, ",") {
+						tagsc = append(tagsc, strings.TrimSpace(tag))
+					}
+				}
+				metricTypec := section.Key("type").String()
+				if metricTypec == "" { // if type is not defined, we'll keep the original counter (Inc) behavior
+					metricTypec = "counter"
+				}
+				sampleRatec, _ := section.Key("sample_rate").Float64()
+				if sampleRatec == 0 {
+					sampleRatec = 1.0
+				}
+				sinkc := section.Key("sink").String()
+				if sinkc == "" { // if sink is not overridden, fall back to the [config] default
+					sinkc = cfg.sink
+				}
+				var targetsc []string
+				if raw := section.Key("targets").String(); raw != "" {
+					for _, alias := range strings.Split(raw, ",") {
+						targetsc = append(targetsc, strings.TrimSpace(alias))
+					}
+				}
 				cmd = command{
-					key:   keyc,
-					query: queryc,
-					freq:  freqc,
+					key:          keyc,
+					query:        queryc,
+					freq:         freqc,
+					source:       sourcec,
+					table:        section.Key("table").String(),
+					keyColumn:    section.Key("key_column").String(),
+					valueColumn:  section.Key("value_column").String(),
+					keyTemplate:  section.Key("key_template").String(),
+					valueColumns: valueColumnsc,
+					metricType:   metricTypec,
+					sampleRate:   sampleRatec,
+					tags:         tagsc,
+					sink:         sinkc,
+					targets:      targetsc,
+				}
+				resolvedAliases := resolveTargetAliases(cfg.mysqlTargets, cmd.targets)
+				// a command fanned out across more than one target has to end up with a distinct
+				// key per target, or every target's metrics collide into the same statsd/Prometheus/
+				// Graphite series. If the user didn't opt into that via a "{{.target}}" placeholder,
+				// auto-prefix the alias onto the key instead of silently dropping data.
+				autoPrefixTarget := len(resolvedAliases) > 1 &&
+					!strings.Contains(cmd.key, "{{.target}}") &&
+					!strings.Contains(cmd.keyTemplate, "{{.target}}")
+				if autoPrefixTarget {
+					logger.Notice("command %s targets %d mysql aliases with no {{.target}} placeholder in key/key_template; auto-prefixing the target alias onto the key", cmd.key, len(resolvedAliases))
+				}
+				for _, alias := range resolvedAliases {
+					targetCmd := cmd
+					targetCmd.targetAlias = alias
+					targetCmd.key = strings.Replace(cmd.key, "{{.target}}", alias, -1)
+					targetCmd.keyTemplate = strings.Replace(cmd.keyTemplate, "{{.target}}", alias, -1)
+					if autoPrefixTarget {
+						targetCmd.key = fmt.Sprint(alias, ".", targetCmd.key)
+						if targetCmd.keyTemplate != "" {
+							targetCmd.keyTemplate = fmt.Sprint(alias, ".", targetCmd.keyTemplate)
+						}
+					}
+					if targetCmd.source == "performance_schema" {
+						targetConfig, ok := cfg.mysqlTargets[alias]
+						if !ok {
+							logger.Error("no mysql target configured for alias %s, command %s", alias, targetCmd.key)
+							continue
+						}
+						expanded, err := expandPerformanceSchemaCommand(targetConfig, targetCmd)
+						if err != nil {
+							logger.Error(err.Error())
+						}
+						commands = append(commands, expanded...)
+					} else {
+						commands = append(commands, targetCmd)
+					}
 				}
-				commands = append(commands, cmd)
 			}
 		}
 	}
 	return &cfg, commands
 }
+
+/*
+  isMysqlTargetSection reports whether an ini section is a "[mysql \"alias\"]" target
+  definition rather than [config] or a command section.
+*/
+func isMysqlTargetSection(name string) bool {
+	return strings.HasPrefix(name, "mysql ")
+}
+
+/*
+  mysqlTargetAlias extracts "alias" out of a "mysql \"alias\"" section name.
+*/
+func mysqlTargetAlias(name string) string {
+	return strings.Trim(strings.TrimSpace(strings.TrimPrefix(name, "mysql ")), `"`)
+}
+
+/*
+  parseMysqlTargetConfig reads one mysql target's connection settings out of an ini
+  section. prefix is "mysql_" for the legacy keys under [config] (eg. "mysql_host") and
+  "" for keys under a "[mysql \"alias\"]" section (eg. "host"), since the section name
+  already scopes them to a single target.
+*/
+func parseMysqlTargetConfig(section *ini.Section, prefix string) mysqlTargetConfig {
+	port, _ := section.Key(prefix + "port").Int()
+	if port == 0 {
+		port = 3306
+	}
+	maxOpenConns, _ := section.Key(prefix + "max_open_conns").Int()
+	maxIdleConns, _ := section.Key(prefix + "max_idle_conns").Int()
+	connMaxLifetime, _ := section.Key(prefix + "conn_max_lifetime").Int()
+	return mysqlTargetConfig{
+		host:            section.Key(prefix + "host").String(),
+		user:            section.Key(prefix + "user").String(),
+		pass:            section.Key(prefix + "pass").String(),
+		db:              section.Key(prefix + "db").String(),
+		port:            port,
+		socket:          section.Key(prefix + "socket").String(),
+		defaultsFile:    section.Key(prefix + "defaults_file").String(),
+		maxOpenConns:    maxOpenConns,
+		maxIdleConns:    maxIdleConns,
+		connMaxLifetime: connMaxLifetime,
+		tlsCa:           section.Key(prefix + "tls_ca").String(),
+		tlsCert:         section.Key(prefix + "tls_cert").String(),
+		tlsKey:          section.Key(prefix + "tls_key").String(),
+	}
+}
+
+/*
+  resolveTargetAliases expands a command's requested "targets" list ("*" means every
+  configured mysql target) into a concrete, deduplicated, deterministically ordered list
+  of aliases to fan the command out to. An empty requested list also means "*", so
+  existing single-target configs (with no "targets" key at all) keep working unchanged.
+*/
+func resolveTargetAliases(targets map[string]mysqlTargetConfig, requested []string) []string {
+	if len(requested) == 0 {
+		requested = []string{"*"}
+	}
+	seen := make(map[string]bool)
+	var aliases []string
+	for _, want := range requested {
+		want = strings.TrimSpace(want)
+		if want == "*" {
+			for alias := range targets {
+				if !seen[alias] {
+					seen[alias] = true
+					aliases = append(aliases, alias)
+				}
+			}
+			continue
+		}
+		if !seen[want] {
+			seen[want] = true
+			aliases = append(aliases, want)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
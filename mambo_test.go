@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"text/template"
+)
+
+func TestResolveTargetAliases(t *testing.T) {
+	targets := map[string]mysqlTargetConfig{
+		"replica1": {},
+		"replica2": {},
+		"master":   {},
+	}
+
+	cases := []struct {
+		name      string
+		targets   map[string]mysqlTargetConfig
+		requested []string
+		want      []string
+	}{
+		{
+			name:      "empty requested defaults to every configured target",
+			targets:   targets,
+			requested: nil,
+			want:      []string{"master", "replica1", "replica2"},
+		},
+		{
+			name:      "star expands to every configured target",
+			targets:   targets,
+			requested: []string{"*"},
+			want:      []string{"master", "replica1", "replica2"},
+		},
+		{
+			name:      "single explicit alias",
+			targets:   targets,
+			requested: []string{"replica1"},
+			want:      []string{"replica1"},
+		},
+		{
+			name:      "multiple explicit aliases are deduplicated and sorted",
+			targets:   targets,
+			requested: []string{"replica2", "replica1", "replica2"},
+			want:      []string{"replica1", "replica2"},
+		},
+		{
+			name:      "whitespace around aliases is trimmed",
+			targets:   targets,
+			requested: []string{" replica1 ", " master"},
+			want:      []string{"master", "replica1"},
+		},
+		{
+			name:      "star mixed with an explicit alias still dedupes",
+			targets:   targets,
+			requested: []string{"replica1", "*"},
+			want:      []string{"master", "replica1", "replica2"},
+		},
+		{
+			name:      "an alias absent from the configured targets is still requested verbatim",
+			targets:   targets,
+			requested: []string{"doesnotexist"},
+			want:      []string{"doesnotexist"},
+		},
+		{
+			name:      "no configured targets and no request resolves to nothing",
+			targets:   map[string]mysqlTargetConfig{},
+			requested: nil,
+			want:      nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := resolveTargetAliases(tc.targets, tc.requested)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("resolveTargetAliases(%v, %v) = %v, want %v", tc.targets, tc.requested, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplatedKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		tmpl    string
+		row     map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "single column substitution",
+			tmpl: "mambo.slave_status.{{.channel_name}}",
+			row:  map[string]interface{}{"channel_name": "repl1"},
+			want: "mambo.slave_status.repl1",
+		},
+		{
+			name: "multiple fields in one template",
+			tmpl: "mambo.{{.schema}}.{{.table}}",
+			row:  map[string]interface{}{"schema": "app", "table": "users"},
+			want: "mambo.app.users",
+		},
+		{
+			name: "template with no fields renders verbatim",
+			tmpl: "mambo.replication_lag",
+			row:  map[string]interface{}{"channel_name": "repl1"},
+			want: "mambo.replication_lag",
+		},
+		{
+			name: "template referencing a missing row key renders the zero value rather than failing",
+			tmpl: "mambo.{{.missing_column}}",
+			row:  map[string]interface{}{"channel_name": "repl1"},
+			want: "mambo.<no value>",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := template.New("test").Parse(tc.tmpl)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned unexpected error: %v", tc.tmpl, err)
+			}
+			got, err := renderTemplatedKey(tmpl, tc.row)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("renderTemplatedKey(%q, %v) = %q, nil; want error", tc.tmpl, tc.row, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplatedKey(%q, %v) returned unexpected error: %v", tc.tmpl, tc.row, err)
+			}
+			if got != tc.want {
+				t.Errorf("renderTemplatedKey(%q, %v) = %q, want %q", tc.tmpl, tc.row, got, tc.want)
+			}
+		})
+	}
+}